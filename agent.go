@@ -1,11 +1,13 @@
 package telegraf
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/influxdb/telegraf/outputs"
@@ -17,12 +19,58 @@ import (
 type runningOutput struct {
 	name   string
 	output outputs.Output
+	buffer Buffer
+	filter *Filter
+
+	// configHash identifies the output's config subsection as it looked
+	// when output/filter were last (re)created, so Reload can tell a
+	// genuinely changed config apart from one that's merely still
+	// declared.
+	configHash string
+
+	// mu guards output, filter, configHash, writing and backoff above and
+	// below. writeOutput both reads the buffer's unacked prefix and acks
+	// it, which isn't safe to do from two goroutines at once, so writing
+	// ensures at most one writeOutput call is in flight for this output at
+	// any time; it also keeps Reload from swapping out output/filter
+	// while a write is using them.
+	mu      sync.Mutex
+	writing bool
+
+	// backoff tracks consecutive write failures so retries can back off
+	// instead of hammering a down output.
+	backoff time.Duration
+}
+
+// tryAcquireWriter reports whether the caller may proceed with a
+// writeOutput call for ro, marking it busy if so. Returns false if a
+// previous writeOutput for this output is still in flight.
+func (ro *runningOutput) tryAcquireWriter() bool {
+	ro.mu.Lock()
+	defer ro.mu.Unlock()
+	if ro.writing {
+		return false
+	}
+	ro.writing = true
+	return true
+}
+
+// releaseWriter marks ro as no longer having a writeOutput call in flight.
+func (ro *runningOutput) releaseWriter() {
+	ro.mu.Lock()
+	ro.writing = false
+	ro.mu.Unlock()
 }
 
 type runningPlugin struct {
 	name   string
 	plugin plugins.Plugin
 	config *ConfiguredPlugin
+
+	// configHash identifies the plugin's config subsection as it looked
+	// when this instance was created, so Reload can tell a genuinely
+	// changed config apart from one that's merely still declared.
+	configHash string
 }
 
 // Agent runs telegraf and collects data based on the given config
@@ -35,8 +83,38 @@ type Agent struct {
 	FlushInterval Duration
 
 	// FlushRetries is the number of times to retry each data flush
+	//
+	// TODO(cam): FlushRetries is no longer consulted now that failed
+	// writes are queued to each output's Buffer and retried on a backoff
+	// instead of being dropped. Leaving it here for now for backwards-
+	// compatability with existing configs.
 	FlushRetries int
 
+	// BufferStrategy selects how points that an output has failed to write
+	// are queued for retry: "memory" (the default) keeps them in an
+	// in-memory slice, "disk" persists them to a BoltDB file per output so
+	// they survive a process restart.
+	BufferStrategy string
+
+	// BufferPath is the directory disk-backed output buffers are stored
+	// in. Required when BufferStrategy is "disk".
+	BufferPath string
+
+	// MaxBufferSize is the maximum number of points an output's buffer
+	// will hold before applying BufferDropPolicy. Zero means unbounded.
+	MaxBufferSize int
+
+	// BufferDropPolicy controls which points are discarded once an
+	// output's buffer reaches MaxBufferSize: "drop-oldest" (the default)
+	// or "drop-newest".
+	BufferDropPolicy string
+
+	// CollectionTimeout bounds how long the agent will wait for a single
+	// plugin's Gather to complete before abandoning it and moving on with
+	// the rest of the collection cycle. Zero means no timeout. A plugin
+	// can override this via its own ConfiguredPlugin.CollectionTimeout.
+	CollectionTimeout Duration
+
 	// TODO(cam): Remove UTC and Precision parameters, they are no longer
 	// valid for the agent config. Leaving them here for now for backwards-
 	// compatability
@@ -54,19 +132,67 @@ type Agent struct {
 
 	Tags map[string]string
 
-	outputs []*runningOutput
-	plugins []*runningPlugin
+	outputs     []*runningOutput
+	plugins     []*runningPlugin
+	processors  []*runningProcessor
+	aggregators []*runningAggregator
+
+	// reloadConfig, pluginFilters and outputFilters remember what Run
+	// should hand to WatchForReload/Reload on a SIGHUP, so the agent can
+	// watch for its own config changes without a caller having to keep a
+	// separate reference to the Config it was built from.
+	reloadConfig  *Config
+	pluginFilters []string
+	outputFilters []string
+
+	// pointChan is the channel gatherParallel and gatherSeparate publish
+	// collected points on. Run creates it once and stores it here so that
+	// a later Reload can start a gatherSeparate goroutine for a newly
+	// added or reconfigured plugin on the same channel the rest of the
+	// pipeline is already reading from.
+	pointChan chan *client.Point
+
+	// gatherShutdowns holds the shutdown channel for each currently
+	// running plugin that has its own collection interval, keyed by
+	// plugin name. Reload closes and replaces these individually so it
+	// can stop or restart one plugin's gatherSeparate goroutine without
+	// disturbing any other plugin's.
+	gatherShutdowns map[string]chan struct{}
+
+	// gatherWG tracks every gatherSeparate goroutine started by
+	// startGatherLoop, whether at Run startup or later by Reload, so Run
+	// can wait for all of them to actually exit on shutdown instead of
+	// just signalling them to stop.
+	gatherWG sync.WaitGroup
+
+	// reloadMu guards the outputs/plugins slices and the gather-loop
+	// bookkeeping above, so that Reload can swap them out while
+	// gatherParallel and flush are concurrently ranging over them.
+	reloadMu sync.RWMutex
+
+	// gatherErrors counts Gather calls that returned an error, panicked,
+	// or timed out, across all plugins. Read/written atomically since
+	// plugins gather concurrently.
+	gatherErrors uint64
+}
+
+// incGatherErrors increments the agent's running count of failed Gather
+// calls (errors, panics and timeouts alike).
+func (a *Agent) incGatherErrors() {
+	atomic.AddUint64(&a.gatherErrors, 1)
 }
 
 // NewAgent returns an Agent struct based off the given Config
 func NewAgent(config *Config) (*Agent, error) {
 	agent := &Agent{
-		Tags:          make(map[string]string),
-		Interval:      Duration{10 * time.Second},
-		FlushInterval: Duration{10 * time.Second},
-		FlushRetries:  2,
-		UTC:           true,
-		Precision:     "s",
+		Tags:             make(map[string]string),
+		Interval:         Duration{10 * time.Second},
+		FlushInterval:    Duration{10 * time.Second},
+		FlushRetries:     2,
+		UTC:              true,
+		Precision:        "s",
+		BufferStrategy:   "memory",
+		BufferDropPolicy: string(DropOldest),
 	}
 
 	// Apply the toml table to the agent config, overriding defaults
@@ -75,6 +201,10 @@ func NewAgent(config *Config) (*Agent, error) {
 		return nil, err
 	}
 
+	if agent.BufferStrategy == "disk" && agent.BufferPath == "" {
+		return nil, fmt.Errorf("agent: buffer_path is required when buffer_strategy is \"disk\"")
+	}
+
 	if agent.Hostname == "" {
 		hostname, err := os.Hostname()
 		if err != nil {
@@ -116,10 +246,22 @@ func (a *Agent) Close() error {
 	var err error
 	for _, o := range a.outputs {
 		err = o.output.Close()
+		if berr := o.buffer.Close(); berr != nil {
+			err = berr
+		}
 	}
 	return err
 }
 
+// bufferDir returns the directory disk-backed output buffers should be
+// stored in, or "" if the agent is configured to buffer in memory.
+func (a *Agent) bufferDir() string {
+	if a.BufferStrategy != "disk" {
+		return ""
+	}
+	return a.BufferPath
+}
+
 // LoadOutputs loads the agent's outputs
 func (a *Agent) LoadOutputs(filters []string, config *Config) ([]string, error) {
 	var names []string
@@ -141,13 +283,34 @@ func (a *Agent) LoadOutputs(filters []string, config *Config) ([]string, error)
 				return nil, err
 			}
 
-			a.outputs = append(a.outputs, &runningOutput{name, output})
+			buffer, err := newBuffer(name, a.MaxBufferSize, BufferDropPolicy(a.BufferDropPolicy), a.bufferDir())
+			if err != nil {
+				return nil, err
+			}
+
+			filter, err := config.OutputFilter(name)
+			if err != nil {
+				return nil, err
+			}
+			if err := filter.Compile(); err != nil {
+				return nil, fmt.Errorf("output %s: %s", name, err)
+			}
+
+			hash, err := config.OutputConfigHash(name)
+			if err != nil {
+				return nil, err
+			}
+
+			a.outputs = append(a.outputs, &runningOutput{name: name, output: output, buffer: buffer, filter: filter, configHash: hash})
 			names = append(names, name)
 		}
 	}
 
 	sort.Strings(names)
 
+	a.reloadConfig = config
+	a.outputFilters = filters
+
 	return names, nil
 }
 
@@ -164,18 +327,26 @@ func (a *Agent) LoadPlugins(filters []string, config *Config) ([]string, error)
 		if sliceContains(name, filters) || len(filters) == 0 {
 			plugin := creator()
 
-			config, err := config.ApplyPlugin(name, plugin)
+			hash, err := config.PluginConfigHash(name)
 			if err != nil {
 				return nil, err
 			}
 
-			a.plugins = append(a.plugins, &runningPlugin{name, plugin, config})
+			pluginConfig, err := config.ApplyPlugin(name, plugin)
+			if err != nil {
+				return nil, err
+			}
+
+			a.plugins = append(a.plugins, &runningPlugin{name: name, plugin: plugin, config: pluginConfig, configHash: hash})
 			names = append(names, name)
 		}
 	}
 
 	sort.Strings(names)
 
+	a.reloadConfig = config
+	a.pluginFilters = filters
+
 	return names, nil
 }
 
@@ -186,7 +357,12 @@ func (a *Agent) gatherParallel(pointChan chan *client.Point) error {
 
 	start := time.Now()
 	counter := 0
-	for _, plugin := range a.plugins {
+
+	a.reloadMu.RLock()
+	currentPlugins := a.plugins
+	a.reloadMu.RUnlock()
+
+	for _, plugin := range currentPlugins {
 		if plugin.config.Interval != 0 {
 			continue
 		}
@@ -196,15 +372,14 @@ func (a *Agent) gatherParallel(pointChan chan *client.Point) error {
 		go func(plugin *runningPlugin) {
 			defer wg.Done()
 
+			time.Sleep(a.jitter())
+
 			acc := NewAccumulator(plugin.config, pointChan)
 			acc.SetDebug(a.Debug)
 			acc.SetPrefix(plugin.name + "_")
 			acc.SetDefaultTags(a.Tags)
 
-			if err := plugin.plugin.Gather(acc); err != nil {
-				log.Printf("Error in plugin [%s]: %s", plugin.name, err)
-			}
-
+			a.gatherWithTimeout(context.Background(), plugin, acc)
 		}(plugin)
 	}
 
@@ -234,9 +409,7 @@ func (a *Agent) gatherSeparate(
 		acc.SetPrefix(plugin.name + "_")
 		acc.SetDefaultTags(a.Tags)
 
-		if err := plugin.plugin.Gather(acc); err != nil {
-			log.Printf("Error in plugin [%s]: %s", plugin.name, err)
-		}
+		a.gatherWithTimeout(context.Background(), plugin, acc)
 
 		elapsed := time.Since(start)
 		log.Printf("Gathered metrics, (separate %s interval), from %s in %s\n",
@@ -255,6 +428,42 @@ func (a *Agent) gatherSeparate(
 	}
 }
 
+// startGatherLoop starts a gatherSeparate goroutine for plugin if it has
+// its own collection interval, recording its shutdown channel under
+// plugin.name so a later call to stopGatherLoop can stop this plugin's
+// loop without affecting any other plugin. Must be called with reloadMu
+// held, and only after a.pointChan has been set.
+func (a *Agent) startGatherLoop(plugin *runningPlugin) {
+	if plugin.config.Interval == 0 {
+		return
+	}
+
+	pluginShutdown := make(chan struct{})
+	if a.gatherShutdowns == nil {
+		a.gatherShutdowns = make(map[string]chan struct{})
+	}
+	a.gatherShutdowns[plugin.name] = pluginShutdown
+
+	a.gatherWG.Add(1)
+	go func() {
+		defer a.gatherWG.Done()
+		if err := a.gatherSeparate(pluginShutdown, plugin, a.pointChan); err != nil {
+			log.Printf(err.Error())
+		}
+	}()
+}
+
+// stopGatherLoop stops the gatherSeparate goroutine running for name, if
+// any, and stops tracking it. Must be called with reloadMu held.
+func (a *Agent) stopGatherLoop(name string) {
+	pluginShutdown, ok := a.gatherShutdowns[name]
+	if !ok {
+		return
+	}
+	close(pluginShutdown)
+	delete(a.gatherShutdowns, name)
+}
+
 // Test verifies that we can 'Gather' from all plugins with their configured
 // Config struct
 func (a *Agent) Test() error {
@@ -293,55 +502,184 @@ func (a *Agent) Test() error {
 	return nil
 }
 
-// writeOutput writes a list of points to a single output, with retries
-func (a *Agent) writeOutput(
-	points []*client.Point,
-	ro *runningOutput,
-	shutdown chan struct{},
-) {
-	retry := 0
-	retries := a.FlushRetries
-	start := time.Now()
+// metricBatchSize is the maximum number of buffered points a single write
+// to an output will contain.
+const metricBatchSize = 1000
+
+// maxBackoff caps the delay writeOutput waits between retries of a failing
+// output, no matter how many consecutive failures it has seen.
+const maxBackoff = time.Minute
+
+// writeOutput drains up to metricBatchSize points from ro's buffer and
+// writes them to the output. Points are only removed from the buffer once
+// the write succeeds, so a failed write loses no data: the output just
+// falls behind (until its buffer reaches MaxBufferSize and applies its drop
+// policy), backing off exponentially (capped at maxBackoff) so a down
+// output isn't hammered.
+func (a *Agent) writeOutput(ro *runningOutput, shutdown chan struct{}) {
+	// Only one writeOutput call may be draining ro's buffer at a time: two
+	// overlapping calls would both Next() the same unacked prefix and
+	// both Ack() it, with the second Ack stripping whatever is now at the
+	// front of the already-shrunk buffer instead of what it actually
+	// wrote.
+	if !ro.tryAcquireWriter() {
+		return
+	}
+	defer ro.releaseWriter()
 
-	for {
-		err := ro.output.Write(points)
+	if ro.buffer.Len() == 0 {
+		return
+	}
 
+	ro.mu.Lock()
+	backoff := ro.backoff
+	output := ro.output
+	ro.mu.Unlock()
+
+	if backoff > 0 {
 		select {
 		case <-shutdown:
 			return
-		default:
-			if err == nil {
-				// Write successful
-				elapsed := time.Since(start)
-				log.Printf("Flushed %d metrics to output %s in %s\n",
-					len(points), ro.name, elapsed)
-				return
-			} else if retry >= retries {
-				// No more retries
-				msg := "FATAL: Write to output [%s] failed %d times, dropping" +
-					" %d metrics\n"
-				log.Printf(msg, ro.name, retries+1, len(points))
-				return
-			} else if err != nil {
-				// Sleep for a retry
-				log.Printf("Error in output [%s]: %s, retrying in %s",
-					ro.name, err.Error(), a.FlushInterval.Duration)
-				time.Sleep(a.FlushInterval.Duration)
-			}
+		case <-time.After(backoff):
+		}
+	}
+
+	points, cursors, err := ro.buffer.Next(metricBatchSize)
+	if err != nil {
+		log.Printf("Error reading buffered points for output %s: %s\n", ro.name, err.Error())
+		return
+	}
+	if len(points) == 0 {
+		return
+	}
+
+	start := time.Now()
+	if err := output.Write(points); err != nil {
+		ro.mu.Lock()
+		if ro.backoff == 0 {
+			ro.backoff = a.FlushInterval.Duration
+		} else {
+			ro.backoff *= 2
 		}
+		if ro.backoff > maxBackoff {
+			ro.backoff = maxBackoff
+		}
+		backoff = ro.backoff
+		ro.mu.Unlock()
+		log.Printf("Error in output [%s]: %s, %d metrics remain buffered, retrying in %s\n",
+			ro.name, err.Error(), ro.buffer.Len(), backoff)
+		return
+	}
+
+	if err := ro.buffer.Ack(cursors); err != nil {
+		log.Printf("Error acking flushed points for output %s: %s\n", ro.name, err.Error())
+		return
+	}
 
-		retry++
+	ro.mu.Lock()
+	ro.backoff = 0
+	ro.mu.Unlock()
+
+	elapsed := time.Since(start)
+	log.Printf("Flushed %d metrics to output %s in %s\n", len(points), ro.name, elapsed)
+}
+
+// route returns the subset of points that should be sent to an output
+// configured with the given filter. A nil or inactive filter passes every
+// point through unchanged, preserving the agent's default behaviour of
+// sending every point to every output.
+func (a *Agent) route(points []*client.Point, filter *Filter) []*client.Point {
+	if filter == nil || !filter.IsActive() {
+		return points
 	}
+
+	routed := make([]*client.Point, 0, len(points))
+	for _, pt := range points {
+		if filter.ShouldPass(pt) {
+			routed = append(routed, pt)
+		}
+	}
+	return routed
 }
 
-// flush writes a list of points to all configured outputs
+// flush routes a list of points to each configured output's Filter, queues
+// whatever passes onto that output's buffer, and kicks off a write attempt.
+// A slow or down output only delays its own buffer; a filter that matches
+// nothing for an output simply leaves it idle this tick.
 func (a *Agent) flush(points []*client.Point, shutdown chan struct{}) {
 	if len(points) == 0 {
 		return
 	}
 
-	for _, o := range a.outputs {
-		go a.writeOutput(points, o, shutdown)
+	a.reloadMu.RLock()
+	currentOutputs := a.outputs
+	a.reloadMu.RUnlock()
+
+	for _, o := range currentOutputs {
+		o.mu.Lock()
+		filter := o.filter
+		o.mu.Unlock()
+
+		routed := a.route(points, filter)
+		if len(routed) == 0 {
+			continue
+		}
+
+		if err := o.buffer.Add(routed...); err != nil {
+			log.Printf("Error buffering points for output %s: %s\n", o.name, err.Error())
+			continue
+		}
+		go a.writeOutput(o, shutdown)
+	}
+}
+
+// bufferStats emits an internal telegraf point per output describing how
+// many points are currently queued in its buffer, so buffer growth (and
+// therefore output backpressure) is observable without touching the host.
+func (a *Agent) bufferStats(pointChan chan *client.Point) {
+	a.reloadMu.RLock()
+	currentOutputs := a.outputs
+	a.reloadMu.RUnlock()
+
+	for _, o := range currentOutputs {
+		pt, err := client.NewPoint(
+			"telegraf_buffer",
+			map[string]string{"output": o.name},
+			map[string]interface{}{"buffer_size": o.buffer.Len()},
+			time.Now(),
+		)
+		if err != nil {
+			log.Printf("Error creating internal buffer stat for output %s: %s\n", o.name, err.Error())
+			continue
+		}
+
+		select {
+		case pointChan <- pt:
+		default:
+			log.Println("Could not write buffer stats, pointChan is full")
+		}
+	}
+}
+
+// gatherStats emits an internal telegraf point reporting the running count
+// of Gather calls that have errored, panicked, or timed out, so plugin
+// health is observable alongside the metrics it produces.
+func (a *Agent) gatherStats(pointChan chan *client.Point) {
+	pt, err := client.NewPoint(
+		"telegraf_gather",
+		map[string]string{"host": a.Hostname},
+		map[string]interface{}{"errors": atomic.LoadUint64(&a.gatherErrors)},
+		time.Now(),
+	)
+	if err != nil {
+		log.Printf("Error creating internal gather stat: %s\n", err.Error())
+		return
+	}
+
+	select {
+	case pointChan <- pt:
+	default:
+		log.Println("Could not write gather stats, pointChan is full")
 	}
 }
 
@@ -360,6 +698,8 @@ func (a *Agent) flusher(shutdown chan struct{}, pointChan chan *client.Point) er
 			return nil
 		case <-ticker.C:
 			a.flush(points, shutdown)
+			a.bufferStats(pointChan)
+			a.gatherStats(pointChan)
 			points = make([]*client.Point, 0)
 		case pt := <-pointChan:
 			points = append(points, pt)
@@ -374,15 +714,39 @@ func (a *Agent) Run(shutdown chan struct{}) error {
 	// channel shared between all plugin threads for accumulating points
 	pointChan := make(chan *client.Point, 1000)
 
+	// channel the pipeline (processors + aggregators) forwards its output
+	// on to; this is what the flusher actually reads from.
+	processedChan := make(chan *client.Point, 1000)
+
+	a.logPipeline()
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if err := a.flusher(shutdown, pointChan); err != nil {
+		a.pipeline(shutdown, pointChan, processedChan)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := a.flusher(shutdown, processedChan); err != nil {
 			log.Printf("Flusher routine failed, exiting: %s\n", err.Error())
 			close(shutdown)
 		}
 	}()
 
+	a.reloadMu.Lock()
+	a.pointChan = pointChan
+	a.reloadMu.Unlock()
+
+	if a.reloadConfig != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.WatchForReload(shutdown, a.reloadConfig, a.pluginFilters, a.outputFilters)
+		}()
+	}
+
 	for _, plugin := range a.plugins {
 
 		// Start service of any ServicePlugins
@@ -396,20 +760,35 @@ func (a *Agent) Run(shutdown chan struct{}) error {
 			defer p.Stop()
 		}
 
-		// Special handling for plugins that have their own collection interval
-		// configured. Default intervals are handled below with gatherParallel
-		if plugin.config.Interval != 0 {
-			wg.Add(1)
-			go func(plugin *runningPlugin) {
-				defer wg.Done()
-				if err := a.gatherSeparate(shutdown, plugin, pointChan); err != nil {
-					log.Printf(err.Error())
-				}
-			}(plugin)
-		}
+		// Special handling for plugins that have their own collection
+		// interval configured. Default intervals are handled below with
+		// gatherParallel. startGatherLoop records this goroutine's
+		// shutdown channel by plugin name so a later Reload can stop or
+		// restart it on its own, independent of every other plugin.
+		a.reloadMu.Lock()
+		a.startGatherLoop(plugin)
+		a.reloadMu.Unlock()
 	}
 
-	defer wg.Wait()
+	// Once the agent itself is shutting down, stop whatever per-plugin
+	// collection loops are still running. Reload can start or stop these
+	// at any time, so they're tracked by gatherWG rather than wg; this is
+	// what signals them to exit instead.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-shutdown
+		a.reloadMu.Lock()
+		for name := range a.gatherShutdowns {
+			a.stopGatherLoop(name)
+		}
+		a.reloadMu.Unlock()
+	}()
+
+	defer func() {
+		wg.Wait()
+		a.gatherWG.Wait()
+	}()
 
 	ticker := time.NewTicker(a.Interval.Duration)
 
@@ -0,0 +1,331 @@
+package telegraf
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/influxdb/influxdb/client/v2"
+)
+
+// BufferDropPolicy controls which points a Buffer discards once it has
+// reached its configured maximum size.
+type BufferDropPolicy string
+
+const (
+	// DropOldest discards the oldest queued points to make room for new
+	// ones. This is the default, since recent data is usually more useful
+	// than stale data.
+	DropOldest BufferDropPolicy = "drop-oldest"
+
+	// DropNewest discards incoming points instead of evicting anything
+	// already queued.
+	DropNewest BufferDropPolicy = "drop-newest"
+)
+
+// boltPointsBucket is the bucket under which a diskBuffer stores its points.
+var boltPointsBucket = []byte("points")
+
+// BufferCursor identifies a single point queued in a Buffer, independent of
+// its current position. Next hands out cursors alongside the points they
+// name so that Ack can remove exactly those entries even if Add has since
+// evicted or reordered anything around them.
+type BufferCursor uint64
+
+// Buffer queues points that an output has failed to write so that they can
+// be retried later. Implementations must be safe for concurrent use, since
+// the agent's flusher adds points while a per-output writeOutput goroutine
+// drains them.
+type Buffer interface {
+	// Add enqueues points, applying the buffer's drop policy if doing so
+	// would exceed the buffer's maximum size.
+	Add(points ...*client.Point) error
+
+	// Next returns up to n queued points, without removing them, along
+	// with a cursor for each identifying its place in the buffer. Callers
+	// must call Ack with the same cursors once the points have been
+	// durably written, or the points will be returned again by a later
+	// Next.
+	Next(n int) ([]*client.Point, []BufferCursor, error)
+
+	// Ack removes the points named by cursors from the buffer. A cursor
+	// that no longer refers to a queued point (for example because Add
+	// already evicted it) is ignored, so Ack is always safe to call with
+	// cursors from a Next call that raced an eviction.
+	Ack(cursors []BufferCursor) error
+
+	// Len returns the number of points currently queued.
+	Len() int
+
+	// Close releases any resources held by the buffer.
+	Close() error
+}
+
+// newBuffer constructs a Buffer for the named output according to the
+// agent's configured buffering strategy.
+func newBuffer(name string, maxSize int, dropPolicy BufferDropPolicy, path string) (Buffer, error) {
+	switch dropPolicy {
+	case "":
+		dropPolicy = DropOldest
+	case DropOldest, DropNewest:
+		// valid
+	default:
+		return nil, fmt.Errorf("invalid buffer drop policy: %s", dropPolicy)
+	}
+
+	if path == "" {
+		return newMemoryBuffer(maxSize, dropPolicy), nil
+	}
+	return newDiskBuffer(name, maxSize, dropPolicy, path)
+}
+
+// bufferedPoint pairs a queued point with the cursor it was assigned when
+// added, so Ack can identify it regardless of what Add has done to the
+// queue since.
+type bufferedPoint struct {
+	cursor BufferCursor
+	point  *client.Point
+}
+
+// memoryBuffer is the default Buffer implementation. Points are held only
+// in process memory and are lost on restart.
+type memoryBuffer struct {
+	sync.Mutex
+
+	maxSize    int
+	dropPolicy BufferDropPolicy
+	points     []bufferedPoint
+	nextCursor BufferCursor
+}
+
+func newMemoryBuffer(maxSize int, dropPolicy BufferDropPolicy) *memoryBuffer {
+	return &memoryBuffer{
+		maxSize:    maxSize,
+		dropPolicy: dropPolicy,
+	}
+}
+
+func (b *memoryBuffer) Add(points ...*client.Point) error {
+	b.Lock()
+	defer b.Unlock()
+
+	for _, pt := range points {
+		b.points = append(b.points, bufferedPoint{cursor: b.nextCursor, point: pt})
+		b.nextCursor++
+	}
+
+	if b.maxSize > 0 && len(b.points) > b.maxSize {
+		overflow := len(b.points) - b.maxSize
+		if b.dropPolicy == DropNewest {
+			b.points = b.points[:b.maxSize]
+		} else {
+			b.points = b.points[overflow:]
+		}
+	}
+
+	return nil
+}
+
+func (b *memoryBuffer) Next(n int) ([]*client.Point, []BufferCursor, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if n > len(b.points) {
+		n = len(b.points)
+	}
+	points := make([]*client.Point, n)
+	cursors := make([]BufferCursor, n)
+	for i := 0; i < n; i++ {
+		points[i] = b.points[i].point
+		cursors[i] = b.points[i].cursor
+	}
+	return points, cursors, nil
+}
+
+func (b *memoryBuffer) Ack(cursors []BufferCursor) error {
+	b.Lock()
+	defer b.Unlock()
+
+	acked := make(map[BufferCursor]bool, len(cursors))
+	for _, c := range cursors {
+		acked[c] = true
+	}
+
+	kept := b.points[:0]
+	for _, bp := range b.points {
+		if !acked[bp.cursor] {
+			kept = append(kept, bp)
+		}
+	}
+	b.points = kept
+	return nil
+}
+
+func (b *memoryBuffer) Len() int {
+	b.Lock()
+	defer b.Unlock()
+	return len(b.points)
+}
+
+func (b *memoryBuffer) Close() error {
+	return nil
+}
+
+// diskBuffer is a Buffer backed by a BoltDB file, so that points queued for
+// an output survive a process restart. Each point is stored under a
+// monotonically increasing key so that Next/Ack can page through the queue
+// in FIFO order.
+type diskBuffer struct {
+	sync.Mutex
+
+	maxSize    int
+	dropPolicy BufferDropPolicy
+	db         *bolt.DB
+	nextKey    uint64
+}
+
+func newDiskBuffer(name string, maxSize int, dropPolicy BufferDropPolicy, dir string) (*diskBuffer, error) {
+	path := filepath.Join(dir, fmt.Sprintf("%s.db", name))
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not open buffer file %s: %s", path, err)
+	}
+
+	var nextKey uint64
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(boltPointsBucket)
+		if err != nil {
+			return err
+		}
+		if k, _ := b.Cursor().Last(); k != nil {
+			nextKey = decodeKey(k) + 1
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &diskBuffer{
+		maxSize:    maxSize,
+		dropPolicy: dropPolicy,
+		db:         db,
+		nextKey:    nextKey,
+	}, nil
+}
+
+func (b *diskBuffer) Add(points ...*client.Point) error {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltPointsBucket)
+
+		if b.maxSize > 0 {
+			// Stats reflects the bucket as of the start of this
+			// transaction, not the deletes made below, so the queue
+			// length is tracked here instead.
+			queued := bucket.Stats().KeyN
+			for queued+len(points) > b.maxSize {
+				if b.dropPolicy == DropNewest {
+					points = points[:len(points)-1]
+					if len(points) == 0 {
+						break
+					}
+					continue
+				}
+				k, _ := bucket.Cursor().First()
+				if k == nil {
+					break
+				}
+				if err := bucket.Delete(k); err != nil {
+					return err
+				}
+				queued--
+			}
+		}
+
+		for _, pt := range points {
+			data, err := json.Marshal(pt)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(encodeKey(b.nextKey), data); err != nil {
+				return err
+			}
+			b.nextKey++
+		}
+		return nil
+	})
+}
+
+func (b *diskBuffer) Next(n int) ([]*client.Point, []BufferCursor, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	var points []*client.Point
+	var cursors []BufferCursor
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltPointsBucket).Cursor()
+		for k, v := c.First(); k != nil && len(points) < n; k, v = c.Next() {
+			pt := &client.Point{}
+			if err := json.Unmarshal(v, pt); err != nil {
+				return err
+			}
+			points = append(points, pt)
+			cursors = append(cursors, BufferCursor(decodeKey(k)))
+		}
+		return nil
+	})
+	return points, cursors, err
+}
+
+// Ack deletes the bolt key belonging to each cursor. A cursor whose key no
+// longer exists (Add's eviction already deleted it) is simply a no-op for
+// that key, which is exactly what we want.
+func (b *diskBuffer) Ack(cursors []BufferCursor) error {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltPointsBucket)
+		for _, c := range cursors {
+			if err := bucket.Delete(encodeKey(uint64(c))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *diskBuffer) Len() int {
+	b.Lock()
+	defer b.Unlock()
+
+	var n int
+	b.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(boltPointsBucket).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+func (b *diskBuffer) Close() error {
+	b.Lock()
+	defer b.Unlock()
+	return b.db.Close()
+}
+
+func encodeKey(k uint64) []byte {
+	return []byte(fmt.Sprintf("%020d", k))
+}
+
+func decodeKey(k []byte) uint64 {
+	var n uint64
+	fmt.Sscanf(string(k), "%d", &n)
+	return n
+}
@@ -0,0 +1,254 @@
+package telegraf
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/client/v2"
+)
+
+func mustBufferPoint(t *testing.T, name string) *client.Point {
+	t.Helper()
+	pt, err := client.NewPoint(name, nil, map[string]interface{}{"value": 1}, time.Now())
+	if err != nil {
+		t.Fatalf("client.NewPoint(%s): %s", name, err)
+	}
+	return pt
+}
+
+func TestMemoryBufferDropOldest(t *testing.T) {
+	b := newMemoryBuffer(2, DropOldest)
+	if err := b.Add(mustBufferPoint(t, "a"), mustBufferPoint(t, "b"), mustBufferPoint(t, "c")); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+
+	if b.Len() != 2 {
+		t.Fatalf("expected 2 points to remain, got %d", b.Len())
+	}
+	points, _, err := b.Next(2)
+	if err != nil {
+		t.Fatalf("Next: %s", err)
+	}
+	if points[0].Name() != "b" || points[1].Name() != "c" {
+		t.Fatalf("expected the oldest point to have been dropped, got %s, %s", points[0].Name(), points[1].Name())
+	}
+}
+
+func TestMemoryBufferDropNewest(t *testing.T) {
+	b := newMemoryBuffer(2, DropNewest)
+	if err := b.Add(mustBufferPoint(t, "a"), mustBufferPoint(t, "b"), mustBufferPoint(t, "c")); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+
+	points, _, err := b.Next(2)
+	if err != nil {
+		t.Fatalf("Next: %s", err)
+	}
+	if points[0].Name() != "a" || points[1].Name() != "b" {
+		t.Fatalf("expected the incoming point to have been dropped, got %s, %s", points[0].Name(), points[1].Name())
+	}
+}
+
+func TestMemoryBufferNextAckFIFO(t *testing.T) {
+	b := newMemoryBuffer(0, DropOldest)
+	if err := b.Add(mustBufferPoint(t, "a"), mustBufferPoint(t, "b"), mustBufferPoint(t, "c")); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+
+	points, cursors, err := b.Next(2)
+	if err != nil {
+		t.Fatalf("Next: %s", err)
+	}
+	if len(points) != 2 || points[0].Name() != "a" || points[1].Name() != "b" {
+		t.Fatalf("expected Next to return a, b in order, got %v", points)
+	}
+
+	if err := b.Ack(cursors); err != nil {
+		t.Fatalf("Ack: %s", err)
+	}
+	if b.Len() != 1 {
+		t.Fatalf("expected 1 point to remain after acking the first two, got %d", b.Len())
+	}
+
+	points, _, err = b.Next(1)
+	if err != nil {
+		t.Fatalf("Next: %s", err)
+	}
+	if len(points) != 1 || points[0].Name() != "c" {
+		t.Fatalf("expected the unacked point c to remain, got %v", points)
+	}
+}
+
+// TestMemoryBufferAckSurvivesEviction reproduces the data-loss scenario a
+// position-based Ack would hit: Next reads a batch, an eviction shifts the
+// front of the queue out from under it, and Ack must still only remove the
+// points it actually names, leaving whatever Add queued in the meantime.
+func TestMemoryBufferAckSurvivesEviction(t *testing.T) {
+	b := newMemoryBuffer(2, DropOldest)
+	if err := b.Add(mustBufferPoint(t, "a"), mustBufferPoint(t, "b")); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+
+	points, cursors, err := b.Next(2)
+	if err != nil {
+		t.Fatalf("Next: %s", err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("expected 2 points from Next, got %d", len(points))
+	}
+
+	// Simulate a concurrent Add, racing the in-flight write, evicting "a"
+	// (the oldest) to make room for "c".
+	if err := b.Add(mustBufferPoint(t, "c")); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+	if b.Len() != 2 {
+		t.Fatalf("expected eviction to keep the buffer at maxSize 2, got %d", b.Len())
+	}
+
+	// Ack the batch Next originally returned (a, b). "a" is already gone;
+	// "b" should still be removed; "c" must survive untouched.
+	if err := b.Ack(cursors); err != nil {
+		t.Fatalf("Ack: %s", err)
+	}
+
+	remaining, _, err := b.Next(10)
+	if err != nil {
+		t.Fatalf("Next: %s", err)
+	}
+	if len(remaining) != 1 || remaining[0].Name() != "c" {
+		t.Fatalf("expected only the never-written point c to remain, got %v", remaining)
+	}
+}
+
+func TestMemoryBufferConcurrentAddNextAck(t *testing.T) {
+	b := newMemoryBuffer(0, DropOldest)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.Add(mustBufferPoint(t, "p"))
+		}()
+	}
+
+	var drained int
+	for i := 0; i < 50; i++ {
+		points, cursors, err := b.Next(1)
+		if err != nil {
+			t.Fatalf("Next: %s", err)
+		}
+		if len(points) == 0 {
+			continue
+		}
+		if err := b.Ack(cursors); err != nil {
+			t.Fatalf("Ack: %s", err)
+		}
+		drained++
+	}
+	wg.Wait()
+
+	// Drain whatever Add calls landed after the loop above stopped
+	// keeping up with them.
+	for b.Len() > 0 {
+		points, cursors, err := b.Next(b.Len())
+		if err != nil {
+			t.Fatalf("Next: %s", err)
+		}
+		if err := b.Ack(cursors); err != nil {
+			t.Fatalf("Ack: %s", err)
+		}
+		drained += len(points)
+	}
+
+	if drained != 50 {
+		t.Fatalf("expected all 50 added points to be drained exactly once, got %d", drained)
+	}
+}
+
+func TestDiskBufferPersistsAcrossReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	b, err := newDiskBuffer("test", 0, DropOldest, dir)
+	if err != nil {
+		t.Fatalf("newDiskBuffer: %s", err)
+	}
+	if err := b.Add(mustBufferPoint(t, "a"), mustBufferPoint(t, "b")); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+
+	points, cursors, err := b.Next(1)
+	if err != nil {
+		t.Fatalf("Next: %s", err)
+	}
+	if err := b.Ack(cursors); err != nil {
+		t.Fatalf("Ack: %s", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	_ = points
+
+	reopened, err := newDiskBuffer("test", 0, DropOldest, dir)
+	if err != nil {
+		t.Fatalf("newDiskBuffer (reopen): %s", err)
+	}
+	defer reopened.Close()
+
+	if reopened.Len() != 1 {
+		t.Fatalf("expected the unacked point to survive reopen, got %d queued", reopened.Len())
+	}
+	remaining, _, err := reopened.Next(1)
+	if err != nil {
+		t.Fatalf("Next: %s", err)
+	}
+	if len(remaining) != 1 || remaining[0].Name() != "b" {
+		t.Fatalf("expected the unacked point b to survive reopen, got %v", remaining)
+	}
+
+	// A fresh point added after reopen must not collide with the keys
+	// already used before the restart.
+	if err := reopened.Add(mustBufferPoint(t, "d")); err != nil {
+		t.Fatalf("Add after reopen: %s", err)
+	}
+	if reopened.Len() != 2 {
+		t.Fatalf("expected 2 points queued after reopen and a new Add, got %d", reopened.Len())
+	}
+}
+
+func TestDiskBufferAckSurvivesEviction(t *testing.T) {
+	dir := t.TempDir()
+
+	b, err := newDiskBuffer("test", 2, DropOldest, dir)
+	if err != nil {
+		t.Fatalf("newDiskBuffer: %s", err)
+	}
+	defer b.Close()
+
+	if err := b.Add(mustBufferPoint(t, "a"), mustBufferPoint(t, "b")); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+
+	_, cursors, err := b.Next(2)
+	if err != nil {
+		t.Fatalf("Next: %s", err)
+	}
+
+	if err := b.Add(mustBufferPoint(t, "c")); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+
+	if err := b.Ack(cursors); err != nil {
+		t.Fatalf("Ack: %s", err)
+	}
+
+	remaining, _, err := b.Next(10)
+	if err != nil {
+		t.Fatalf("Next: %s", err)
+	}
+	if len(remaining) != 1 || remaining[0].Name() != "c" {
+		t.Fatalf("expected only the never-written point c to remain, got %v", remaining)
+	}
+}
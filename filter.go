@@ -0,0 +1,111 @@
+package telegraf
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+
+	"github.com/influxdb/influxdb/client/v2"
+)
+
+// Filter holds the namepass/namedrop, tagpass/tagdrop and sampling rules
+// configured for a single output, compiled down to something flush can
+// cheaply evaluate per point. A zero-value Filter passes every point,
+// matching the agent's previous behaviour of sending every point to every
+// output.
+type Filter struct {
+	// NamePass/NameDrop are glob patterns (as in path/filepath.Match)
+	// matched against a point's measurement name. NameDrop is evaluated
+	// first: a name that matches NameDrop is always rejected, regardless
+	// of NamePass.
+	NamePass []string
+	NameDrop []string
+
+	// TagPass/TagDrop map a tag key to the glob patterns its value must
+	// (TagPass) or must not (TagDrop) match. A point missing a tag listed
+	// in TagPass fails that rule.
+	TagPass map[string][]string
+	TagDrop map[string][]string
+
+	// SamplingRatio keeps this fraction, in (0, 1], of the points that
+	// otherwise pass the name/tag rules. Zero (the default) disables
+	// sampling, i.e. every point that passes the other rules is kept.
+	SamplingRatio float64
+
+	isActive bool
+}
+
+// Compile finalizes the filter so it is safe to evaluate concurrently. It
+// must be called once, after the filter's fields are populated from
+// config, before the agent starts routing points through it.
+func (f *Filter) Compile() error {
+	if f.SamplingRatio < 0 || f.SamplingRatio > 1 {
+		return fmt.Errorf("sampling_ratio must be between 0 and 1, got %v", f.SamplingRatio)
+	}
+
+	f.isActive = len(f.NamePass) > 0 || len(f.NameDrop) > 0 ||
+		len(f.TagPass) > 0 || len(f.TagDrop) > 0 || f.SamplingRatio > 0
+
+	return nil
+}
+
+// IsActive reports whether the filter has any rule configured. An inactive
+// filter is a cheap no-op in the hot path.
+func (f *Filter) IsActive() bool {
+	return f.isActive
+}
+
+// ShouldPass returns whether point should be routed to the output this
+// filter belongs to.
+func (f *Filter) ShouldPass(point *client.Point) bool {
+	if !f.isActive {
+		return true
+	}
+
+	if !f.shouldNamePass(point.Name()) {
+		return false
+	}
+	if !f.shouldTagPass(point.Tags()) {
+		return false
+	}
+	if f.SamplingRatio > 0 && rand.Float64() > f.SamplingRatio {
+		return false
+	}
+	return true
+}
+
+func (f *Filter) shouldNamePass(name string) bool {
+	if globMatchAny(f.NameDrop, name) {
+		return false
+	}
+	if len(f.NamePass) > 0 {
+		return globMatchAny(f.NamePass, name)
+	}
+	return true
+}
+
+func (f *Filter) shouldTagPass(tags map[string]string) bool {
+	for key, patterns := range f.TagDrop {
+		if globMatchAny(patterns, tags[key]) {
+			return false
+		}
+	}
+	for key, patterns := range f.TagPass {
+		value, ok := tags[key]
+		if !ok || !globMatchAny(patterns, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// globMatchAny reports whether value matches any of the given
+// filepath.Match-style glob patterns.
+func globMatchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
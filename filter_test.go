@@ -0,0 +1,126 @@
+package telegraf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/client/v2"
+)
+
+func mustPoint(t *testing.T, name string, tags map[string]string) *client.Point {
+	t.Helper()
+	pt, err := client.NewPoint(name, tags, map[string]interface{}{"value": 1}, time.Now())
+	if err != nil {
+		t.Fatalf("client.NewPoint(%s): %s", name, err)
+	}
+	return pt
+}
+
+func TestFilterInactivePassesEverything(t *testing.T) {
+	f := &Filter{}
+	if err := f.Compile(); err != nil {
+		t.Fatalf("Compile: %s", err)
+	}
+	if f.IsActive() {
+		t.Fatalf("expected a filter with no rules to be inactive")
+	}
+	if !f.ShouldPass(mustPoint(t, "cpu", nil)) {
+		t.Fatalf("expected inactive filter to pass every point")
+	}
+}
+
+func TestFilterNamePass(t *testing.T) {
+	f := &Filter{NamePass: []string{"cpu*"}}
+	if err := f.Compile(); err != nil {
+		t.Fatalf("Compile: %s", err)
+	}
+
+	if !f.ShouldPass(mustPoint(t, "cpu_usage", nil)) {
+		t.Errorf("expected cpu_usage to pass NamePass cpu*")
+	}
+	if f.ShouldPass(mustPoint(t, "mem_usage", nil)) {
+		t.Errorf("expected mem_usage to be rejected by NamePass cpu*")
+	}
+}
+
+func TestFilterNameDropWinsOverNamePass(t *testing.T) {
+	f := &Filter{NamePass: []string{"cpu*"}, NameDrop: []string{"cpu_internal"}}
+	if err := f.Compile(); err != nil {
+		t.Fatalf("Compile: %s", err)
+	}
+
+	if f.ShouldPass(mustPoint(t, "cpu_internal", nil)) {
+		t.Errorf("expected NameDrop to reject cpu_internal even though it matches NamePass")
+	}
+	if !f.ShouldPass(mustPoint(t, "cpu_usage", nil)) {
+		t.Errorf("expected cpu_usage to still pass")
+	}
+}
+
+func TestFilterTagPass(t *testing.T) {
+	f := &Filter{TagPass: map[string][]string{"region": {"us-*"}}}
+	if err := f.Compile(); err != nil {
+		t.Fatalf("Compile: %s", err)
+	}
+
+	if !f.ShouldPass(mustPoint(t, "cpu", map[string]string{"region": "us-east"})) {
+		t.Errorf("expected region=us-east to pass TagPass region=us-*")
+	}
+	if f.ShouldPass(mustPoint(t, "cpu", map[string]string{"region": "eu-west"})) {
+		t.Errorf("expected region=eu-west to be rejected by TagPass region=us-*")
+	}
+	if f.ShouldPass(mustPoint(t, "cpu", nil)) {
+		t.Errorf("expected a point missing the region tag to be rejected by TagPass")
+	}
+}
+
+func TestFilterTagDrop(t *testing.T) {
+	f := &Filter{TagDrop: map[string][]string{"host": {"test-*"}}}
+	if err := f.Compile(); err != nil {
+		t.Fatalf("Compile: %s", err)
+	}
+
+	if f.ShouldPass(mustPoint(t, "cpu", map[string]string{"host": "test-1"})) {
+		t.Errorf("expected host=test-1 to be rejected by TagDrop host=test-*")
+	}
+	if !f.ShouldPass(mustPoint(t, "cpu", map[string]string{"host": "prod-1"})) {
+		t.Errorf("expected host=prod-1 to pass TagDrop host=test-*")
+	}
+}
+
+func TestFilterInvalidSamplingRatio(t *testing.T) {
+	f := &Filter{SamplingRatio: 1.5}
+	if err := f.Compile(); err == nil {
+		t.Fatalf("expected Compile to reject a sampling_ratio outside [0, 1]")
+	}
+}
+
+// TestRouteUnrelatedOutputsUnaffected ensures that filtering points for one
+// output's Filter doesn't alter which points another output's Filter (or
+// lack of one) would pass, since Agent.route takes a Filter per call and
+// doesn't share any state across outputs.
+func TestRouteUnrelatedOutputsUnaffected(t *testing.T) {
+	a := &Agent{}
+
+	points := []*client.Point{
+		mustPoint(t, "cpu", nil),
+		mustPoint(t, "mem", nil),
+	}
+
+	cpuOnly := &Filter{NamePass: []string{"cpu"}}
+	if err := cpuOnly.Compile(); err != nil {
+		t.Fatalf("Compile: %s", err)
+	}
+
+	routedToFiltered := a.route(points, cpuOnly)
+	if len(routedToFiltered) != 1 || routedToFiltered[0].Name() != "cpu" {
+		t.Fatalf("expected only the cpu point to be routed to the filtered output, got %v", routedToFiltered)
+	}
+
+	// An output with no filter (or an uncompiled/empty one) must still see
+	// every point, regardless of what the filtered output's rules did.
+	routedToUnfiltered := a.route(points, nil)
+	if len(routedToUnfiltered) != len(points) {
+		t.Fatalf("expected the unfiltered output to see every point, got %v", routedToUnfiltered)
+	}
+}
@@ -0,0 +1,99 @@
+package telegraf
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"runtime/debug"
+	"time"
+
+	"github.com/influxdb/telegraf/plugins"
+)
+
+// maxJitter caps how long gatherParallel will stagger a plugin's start by,
+// regardless of the configured Interval, so a slow collection interval
+// doesn't translate into a pointlessly long jitter window.
+const maxJitter = 5 * time.Second
+
+// ContextGatherer is the context-aware form of Gather, which receives the
+// plugin's CollectionTimeout deadline. A Go type has a single Gather
+// method and plugins.Plugin already requires Gather(Accumulator) error, so
+// no plugins.Plugin value satisfies ContextGatherer: every plugin goes
+// through the legacy call in gather and never sees the context.
+type ContextGatherer interface {
+	Gather(ctx context.Context, acc Accumulator) error
+}
+
+// gather calls Gather on plugin through ContextGatherer when plugin
+// satisfies it, and through the legacy plugins.Plugin interface otherwise
+// (which, as noted on ContextGatherer, is always the case). The legacy path
+// cannot actually be interrupted mid-call, but the caller still bounds how
+// long it waits via gatherWithTimeout.
+func gather(ctx context.Context, plugin plugins.Plugin, acc Accumulator) error {
+	if cg, ok := plugin.(ContextGatherer); ok {
+		return cg.Gather(ctx, acc)
+	}
+	return plugin.Gather(acc)
+}
+
+// collectionTimeout returns the effective per-plugin collection timeout,
+// preferring the plugin's own CollectionTimeout override over the
+// agent-wide default. Zero means no timeout.
+func (a *Agent) collectionTimeout(plugin *runningPlugin) time.Duration {
+	if plugin.config.CollectionTimeout != 0 {
+		return plugin.config.CollectionTimeout.Duration
+	}
+	return a.CollectionTimeout.Duration
+}
+
+// jitter returns a random delay in [0, cap), where cap is a quarter of the
+// agent's collection interval, or maxJitter if that is larger than
+// maxJitter or the interval is zero, so that N plugins sharing an interval
+// don't all fire their Gather in the same instant.
+func (a *Agent) jitter() time.Duration {
+	cap := a.Interval.Duration / 4
+	if cap > maxJitter || cap == 0 {
+		cap = maxJitter
+	}
+	return time.Duration(rand.Int63n(int64(cap)))
+}
+
+// gatherWithTimeout runs a single plugin's Gather, bounding it with a
+// context so a hung plugin can't stall the whole collection cycle
+// indefinitely, and recovering from any panic so a broken plugin can't
+// crash the agent. If the plugin doesn't return within its
+// CollectionTimeout, gatherWithTimeout gives up on it (the legacy
+// goroutine may still be running in the background) and logs the
+// abandonment, incrementing gatherErrors either way.
+func (a *Agent) gatherWithTimeout(ctx context.Context, plugin *runningPlugin, acc Accumulator) {
+	timeout := a.collectionTimeout(plugin)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("PANIC in plugin [%s]: %v\n%s", plugin.name, r, debug.Stack())
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- gather(ctx, plugin.plugin, acc)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			a.incGatherErrors()
+			log.Printf("Error in plugin [%s]: %s", plugin.name, err)
+		}
+	case <-ctx.Done():
+		a.incGatherErrors()
+		log.Printf("Plugin [%s] did not complete Gather within %s, abandoning this collection\n",
+			plugin.name, timeout)
+	}
+}
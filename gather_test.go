@@ -0,0 +1,110 @@
+package telegraf
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/client/v2"
+	"github.com/influxdb/telegraf/plugins"
+)
+
+// fakeGatherPlugin is a plugins.Plugin whose Gather behavior is driven
+// entirely by test code: it can block indefinitely, panic, or just return
+// an error, so gatherWithTimeout's handling of each case can be exercised
+// without a real plugin.
+type fakeGatherPlugin struct {
+	block chan struct{}
+	panic bool
+	err   error
+}
+
+func (p *fakeGatherPlugin) Gather(acc Accumulator) error {
+	if p.block != nil {
+		<-p.block
+	}
+	if p.panic {
+		panic("fakeGatherPlugin panicking on purpose")
+	}
+	return p.err
+}
+
+func newTestRunningPlugin(plugin plugins.Plugin) (*runningPlugin, chan *client.Point) {
+	pointChan := make(chan *client.Point, 10)
+	return &runningPlugin{
+		name:   "fake",
+		plugin: plugin,
+		config: &ConfiguredPlugin{},
+	}, pointChan
+}
+
+func TestGatherWithTimeoutAbandonsASlowPlugin(t *testing.T) {
+	a := &Agent{CollectionTimeout: Duration{50 * time.Millisecond}}
+
+	block := make(chan struct{})
+	defer close(block)
+	rp, pointChan := newTestRunningPlugin(&fakeGatherPlugin{block: block})
+	acc := NewAccumulator(rp.config, pointChan)
+
+	start := time.Now()
+	a.gatherWithTimeout(context.Background(), rp, acc)
+	elapsed := time.Since(start)
+
+	if elapsed >= time.Second {
+		t.Fatalf("expected gatherWithTimeout to give up around the 50ms CollectionTimeout, took %s", elapsed)
+	}
+	if got := atomic.LoadUint64(&a.gatherErrors); got != 1 {
+		t.Fatalf("expected the abandoned collection to count as a gather error, got %d", got)
+	}
+}
+
+func TestGatherWithTimeoutRecoversPanic(t *testing.T) {
+	a := &Agent{}
+
+	rp, pointChan := newTestRunningPlugin(&fakeGatherPlugin{panic: true})
+	acc := NewAccumulator(rp.config, pointChan)
+
+	done := make(chan struct{})
+	go func() {
+		a.gatherWithTimeout(context.Background(), rp, acc)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("gatherWithTimeout did not return after a panicking Gather")
+	}
+
+	if got := atomic.LoadUint64(&a.gatherErrors); got != 1 {
+		t.Fatalf("expected a panicking Gather to count as a gather error, got %d", got)
+	}
+}
+
+func TestGatherWithTimeoutCountsErrors(t *testing.T) {
+	a := &Agent{}
+
+	rp, pointChan := newTestRunningPlugin(&fakeGatherPlugin{err: fmt.Errorf("boom")})
+	acc := NewAccumulator(rp.config, pointChan)
+
+	a.gatherWithTimeout(context.Background(), rp, acc)
+
+	if got := atomic.LoadUint64(&a.gatherErrors); got != 1 {
+		t.Fatalf("expected a failing Gather to count as a gather error, got %d", got)
+	}
+}
+
+func TestGatherWithTimeoutDoesNotCountASuccess(t *testing.T) {
+	a := &Agent{}
+
+	rp, pointChan := newTestRunningPlugin(&fakeGatherPlugin{})
+	acc := NewAccumulator(rp.config, pointChan)
+
+	a.gatherWithTimeout(context.Background(), rp, acc)
+
+	if got := atomic.LoadUint64(&a.gatherErrors); got != 0 {
+		t.Fatalf("expected a clean Gather to not count as a gather error, got %d", got)
+	}
+}
@@ -0,0 +1,196 @@
+package telegraf
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/influxdb/telegraf/plugins/aggregators"
+	"github.com/influxdb/telegraf/plugins/processors"
+
+	"github.com/influxdb/influxdb/client/v2"
+)
+
+type runningProcessor struct {
+	name      string
+	processor processors.Processor
+}
+
+type runningAggregator struct {
+	name       string
+	aggregator aggregators.Aggregator
+	period     time.Duration
+
+	// mu serializes Add and Push on aggregator: Add is called from the
+	// pipeline goroutine as points stream in, while Push is called from
+	// this aggregator's own ticker goroutine, so without a lock here an
+	// aggregator implementation would need its own internal
+	// synchronization just to be used safely.
+	mu sync.Mutex
+}
+
+// add folds pt into ra's current window, serialized against push.
+func (ra *runningAggregator) add(pt *client.Point) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	ra.aggregator.Add(pt)
+}
+
+// push returns ra's summarized points and resets its window, serialized
+// against add.
+func (ra *runningAggregator) push() []*client.Point {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	return ra.aggregator.Push()
+}
+
+// LoadProcessors loads the agent's processors, which run over every point
+// produced by a plugin before it reaches the aggregators or outputs.
+func (a *Agent) LoadProcessors(filters []string, config *Config) ([]string, error) {
+	var names []string
+
+	for _, name := range config.ProcessorsDeclared() {
+		creator, ok := processors.Processors[name]
+		if !ok {
+			return nil, fmt.Errorf("Undefined but requested processor: %s", name)
+		}
+
+		if sliceContains(name, filters) || len(filters) == 0 {
+			processor := creator()
+
+			if err := config.ApplyProcessor(name, processor); err != nil {
+				return nil, err
+			}
+
+			a.processors = append(a.processors, &runningProcessor{name, processor})
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// LoadAggregators loads the agent's aggregators, which summarize points
+// over their own rolling window before handing the summary on to outputs.
+func (a *Agent) LoadAggregators(filters []string, config *Config) ([]string, error) {
+	var names []string
+
+	for _, name := range config.AggregatorsDeclared() {
+		creator, ok := aggregators.Aggregators[name]
+		if !ok {
+			return nil, fmt.Errorf("Undefined but requested aggregator: %s", name)
+		}
+
+		if sliceContains(name, filters) || len(filters) == 0 {
+			aggregator := creator()
+
+			if err := config.ApplyAggregator(name, aggregator); err != nil {
+				return nil, err
+			}
+
+			period := config.AggregatorPeriod(name)
+			if period == 0 {
+				period = aggregators.DefaultPeriod
+			}
+
+			a.aggregators = append(a.aggregators, &runningAggregator{name: name, aggregator: aggregator, period: period})
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// applyProcessors runs pt through every configured processor in order,
+// threading each processor's output into the next one's input.
+func (a *Agent) applyProcessors(pt *client.Point) []*client.Point {
+	points := []*client.Point{pt}
+
+	for _, rp := range a.processors {
+		var next []*client.Point
+		for _, p := range points {
+			next = append(next, rp.processor.Apply(p)...)
+		}
+		points = next
+	}
+
+	return points
+}
+
+// runAggregator pushes ra's summarized points onto outChan once per its
+// configured Period, independent of the agent's FlushInterval.
+func (a *Agent) runAggregator(shutdown chan struct{}, ra *runningAggregator, outChan chan *client.Point) {
+	ticker := time.NewTicker(ra.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+			for _, pt := range ra.push() {
+				select {
+				case outChan <- pt:
+				case <-shutdown:
+					return
+				}
+			}
+		}
+	}
+}
+
+// pipeline is the streaming stage between plugin collection and the
+// flusher: it reads raw points off rawChan, runs them through the
+// configured processors, hands the result to every aggregator, and
+// forwards them on to outChan so the flusher still sees every point.
+// Aggregators additionally get their own ticker (started here) so they can
+// push their window's summary points onto outChan on their own Period.
+func (a *Agent) pipeline(shutdown chan struct{}, rawChan chan *client.Point, outChan chan *client.Point) {
+	var wg sync.WaitGroup
+
+	for _, ra := range a.aggregators {
+		wg.Add(1)
+		go func(ra *runningAggregator) {
+			defer wg.Done()
+			a.runAggregator(shutdown, ra, outChan)
+		}(ra)
+	}
+
+	for {
+		select {
+		case <-shutdown:
+			wg.Wait()
+			return
+		case pt := <-rawChan:
+			for _, out := range a.applyProcessors(pt) {
+				for _, ra := range a.aggregators {
+					ra.add(out)
+				}
+				select {
+				case outChan <- out:
+				case <-shutdown:
+				}
+			}
+		}
+	}
+}
+
+// logPipeline reports which processors and aggregators are active, mainly
+// useful when debugging why a point didn't reach an output as expected.
+func (a *Agent) logPipeline() {
+	if !a.Debug {
+		return
+	}
+	for _, rp := range a.processors {
+		log.Printf("Processor enabled: %s\n", rp.name)
+	}
+	for _, ra := range a.aggregators {
+		log.Printf("Aggregator enabled: %s (period %s)\n", ra.name, ra.period)
+	}
+}
@@ -0,0 +1,120 @@
+package telegraf
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/client/v2"
+)
+
+// doublingProcessor duplicates every point it's given, so a chain of two
+// of them should quadruple the input.
+type doublingProcessor struct{}
+
+func (doublingProcessor) Apply(points ...*client.Point) []*client.Point {
+	out := make([]*client.Point, 0, len(points)*2)
+	for _, pt := range points {
+		out = append(out, pt, pt)
+	}
+	return out
+}
+
+func TestApplyProcessorsChains(t *testing.T) {
+	a := &Agent{
+		processors: []*runningProcessor{
+			{name: "double-1", processor: doublingProcessor{}},
+			{name: "double-2", processor: doublingProcessor{}},
+		},
+	}
+
+	pt := mustBufferPoint(t, "cpu")
+	out := a.applyProcessors(pt)
+
+	if len(out) != 4 {
+		t.Fatalf("expected a chain of two doubling processors to quadruple one point, got %d", len(out))
+	}
+}
+
+// counterAggregator counts how many points it has seen since the last
+// Push, with no locking of its own: it relies entirely on runningAggregator
+// serializing Add and Push.
+type counterAggregator struct {
+	count int
+}
+
+func (c *counterAggregator) Add(pt *client.Point) {
+	c.count++
+}
+
+func (c *counterAggregator) Push() []*client.Point {
+	pt, err := client.NewPoint("count", nil, map[string]interface{}{"value": c.count}, time.Now())
+	if err != nil {
+		panic(err)
+	}
+	c.count = 0
+	return []*client.Point{pt}
+}
+
+func TestRunningAggregatorSerializesConcurrentAddPush(t *testing.T) {
+	ra := &runningAggregator{name: "counter", aggregator: &counterAggregator{}, period: time.Hour}
+
+	const adders = 20
+	const addsPerAdder = 200
+
+	var wg sync.WaitGroup
+	for i := 0; i < adders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < addsPerAdder; j++ {
+				ra.add(mustBufferPoint(t, "cpu"))
+			}
+		}()
+	}
+	wg.Wait()
+
+	pushed := ra.push()
+	if len(pushed) != 1 {
+		t.Fatalf("expected Push to return exactly one summary point, got %d", len(pushed))
+	}
+	fields, err := pushed[0].Fields()
+	if err != nil {
+		t.Fatalf("Fields: %s", err)
+	}
+	if got := fields["value"]; got != int64(adders*addsPerAdder) {
+		t.Fatalf("expected every concurrent Add to be counted exactly once, got %v, want %d", got, adders*addsPerAdder)
+	}
+}
+
+func TestRunAggregatorPushesOnPeriod(t *testing.T) {
+	ra := &runningAggregator{name: "counter", aggregator: &counterAggregator{}, period: 20 * time.Millisecond}
+	ra.add(mustBufferPoint(t, "cpu"))
+	ra.add(mustBufferPoint(t, "cpu"))
+
+	a := &Agent{}
+	shutdown := make(chan struct{})
+	outChan := make(chan *client.Point, 10)
+
+	done := make(chan struct{})
+	go func() {
+		a.runAggregator(shutdown, ra, outChan)
+		close(done)
+	}()
+
+	select {
+	case pt := <-outChan:
+		if pt.Name() != "count" {
+			t.Fatalf("expected the aggregator's summary point, got %s", pt.Name())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected runAggregator to push a summary point within its period")
+	}
+
+	close(shutdown)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected runAggregator to return after shutdown was closed")
+	}
+}
@@ -0,0 +1,41 @@
+package aggregators
+
+import (
+	"time"
+
+	"github.com/influxdb/influxdb/client/v2"
+)
+
+// DefaultPeriod is the rolling window size an aggregator uses when its
+// config doesn't specify its own Period.
+const DefaultPeriod = 30 * time.Second
+
+// Aggregator summarizes points received over a rolling window (mean, min,
+// max, percentiles, a rate/derivative, ...) into new points emitted by
+// Push. The agent calls Add for every point flowing through the pipeline
+// and Push once per Period, then resets the aggregator for the next
+// window. The agent serializes Add and Push for a given Aggregator itself
+// (they're called from different goroutines), so implementations don't
+// need their own locking to stay safe.
+type Aggregator interface {
+	// Add folds point into the current window.
+	Add(point *client.Point)
+
+	// Push returns the points summarizing the current window and resets
+	// the aggregator for the next one.
+	Push() []*client.Point
+}
+
+// Creator is the function that constructs a new, unconfigured instance of
+// an Aggregator. It mirrors plugins.Creator so the two registries stay
+// consistent to work with.
+type Creator func() Aggregator
+
+// Aggregators maps an aggregator's config name to the function that
+// creates it. An aggregator registers itself here from its own init().
+var Aggregators = map[string]Creator{}
+
+// Add registers an Aggregator under name for use in telegraf's config.
+func Add(name string, creator Creator) {
+	Aggregators[name] = creator
+}
@@ -0,0 +1,31 @@
+package processors
+
+import (
+	"github.com/influxdb/influxdb/client/v2"
+)
+
+// Processor transforms points in place before they reach outputs, e.g.
+// renaming tags or converting units. Unlike an aggregators.Aggregator, a
+// Processor does not summarize across a window: every point it is given
+// is turned into zero or more points immediately.
+type Processor interface {
+	// Apply runs the processor's transformation over the given points,
+	// returning the points that should continue on through the pipeline.
+	// Returning fewer points than were passed in drops the rest;
+	// returning more splits a point into several.
+	Apply(points ...*client.Point) []*client.Point
+}
+
+// Creator is the function that constructs a new, unconfigured instance of
+// a Processor. It mirrors plugins.Creator so the two registries stay
+// consistent to work with.
+type Creator func() Processor
+
+// Processors maps a processor's config name to the function that creates
+// it. A processor registers itself here from its own init().
+var Processors = map[string]Creator{}
+
+// Add registers a Processor under name for use in telegraf's config.
+func Add(name string, creator Creator) {
+	Processors[name] = creator
+}
@@ -0,0 +1,307 @@
+package telegraf
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/influxdb/telegraf/outputs"
+	"github.com/influxdb/telegraf/plugins"
+)
+
+// Reload re-parses config, diffs the result against the agent's currently
+// running plugins and outputs, stops whatever was removed (calling
+// ServicePlugin.Stop on service plugins, closing removed outputs), starts
+// whatever was added, and reconnects any plugin or output whose config
+// subsection actually changed value (detected via PluginConfigHash /
+// OutputConfigHash, not just by still being declared under the same
+// name). Run's pipeline keeps draining the same pointChan into the flusher
+// throughout, so metrics produced during the reload window are never
+// dropped.
+func (a *Agent) Reload(config *Config, pluginFilters, outputFilters []string) error {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	if err := a.reloadPlugins(config, pluginFilters); err != nil {
+		return fmt.Errorf("reloading plugins: %s", err)
+	}
+	if err := a.reloadOutputs(config, outputFilters); err != nil {
+		return fmt.Errorf("reloading outputs: %s", err)
+	}
+
+	log.Println("Reloaded config")
+	return nil
+}
+
+// reloadPlugins diffs config's plugin list against a.plugins: plugins no
+// longer declared are stopped, plugins whose config hash changed are
+// restarted in place, and newly declared plugins are started. For any
+// plugin with its own collection interval, this also stops and/or starts
+// the gatherSeparate goroutine collecting for it, so a reload actually
+// changes what gets gathered instead of just the bookkeeping in
+// a.plugins. It must be called with reloadMu held.
+func (a *Agent) reloadPlugins(config *Config, filters []string) error {
+	wanted := make(map[string]bool)
+	for _, name := range config.PluginsDeclared() {
+		if sliceContains(name, filters) || len(filters) == 0 {
+			wanted[name] = true
+		}
+	}
+
+	var kept []*runningPlugin
+	for _, rp := range a.plugins {
+		if !wanted[rp.name] {
+			if sp, ok := rp.plugin.(plugins.ServicePlugin); ok {
+				log.Printf("Stopping removed plugin: %s\n", rp.name)
+				sp.Stop()
+			}
+			a.stopGatherLoop(rp.name)
+			continue
+		}
+
+		hash, err := config.PluginConfigHash(rp.name)
+		if err != nil {
+			return err
+		}
+		if hash == rp.configHash {
+			kept = append(kept, rp)
+			continue
+		}
+
+		log.Printf("Restarting reconfigured plugin: %s\n", rp.name)
+		restarted, err := a.startPlugin(config, rp.name, hash)
+		if err != nil {
+			return err
+		}
+		if sp, ok := rp.plugin.(plugins.ServicePlugin); ok {
+			sp.Stop()
+		}
+		a.stopGatherLoop(rp.name)
+		a.startGatherLoop(restarted)
+		kept = append(kept, restarted)
+	}
+
+	for name := range wanted {
+		if pluginIsRunning(kept, name) {
+			continue
+		}
+
+		hash, err := config.PluginConfigHash(name)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Starting added plugin: %s\n", name)
+		added, err := a.startPlugin(config, name, hash)
+		if err != nil {
+			return err
+		}
+		a.startGatherLoop(added)
+		kept = append(kept, added)
+	}
+
+	a.plugins = kept
+	return nil
+}
+
+// startPlugin creates, configures and (if it's a ServicePlugin) starts a
+// fresh instance of the named plugin, tagging it with hash so a future
+// reload can tell whether its config has changed again.
+func (a *Agent) startPlugin(config *Config, name, hash string) (*runningPlugin, error) {
+	creator, ok := plugins.Plugins[name]
+	if !ok {
+		return nil, fmt.Errorf("Undefined but requested plugin: %s", name)
+	}
+	plugin := creator()
+
+	pluginConfig, err := config.ApplyPlugin(name, plugin)
+	if err != nil {
+		return nil, err
+	}
+
+	if sp, ok := plugin.(plugins.ServicePlugin); ok {
+		if err := sp.Start(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &runningPlugin{name: name, plugin: plugin, config: pluginConfig, configHash: hash}, nil
+}
+
+func pluginIsRunning(running []*runningPlugin, name string) bool {
+	for _, rp := range running {
+		if rp.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadOutputs diffs config's output list against a.outputs: outputs no
+// longer declared are closed, outputs whose config hash changed are
+// reconnected in place (keeping their existing buffer, so nothing already
+// queued for them is lost), and newly declared outputs are connected. It
+// must be called with reloadMu held.
+func (a *Agent) reloadOutputs(config *Config, filters []string) error {
+	wanted := make(map[string]bool)
+	for _, name := range config.OutputsDeclared() {
+		if sliceContains(name, filters) || len(filters) == 0 {
+			wanted[name] = true
+		}
+	}
+
+	var kept []*runningOutput
+	for _, ro := range a.outputs {
+		if !wanted[ro.name] {
+			log.Printf("Closing removed output: %s\n", ro.name)
+			if err := ro.output.Close(); err != nil {
+				log.Printf("Error closing output %s: %s\n", ro.name, err.Error())
+			}
+			if err := ro.buffer.Close(); err != nil {
+				log.Printf("Error closing buffer for output %s: %s\n", ro.name, err.Error())
+			}
+			continue
+		}
+
+		hash, err := config.OutputConfigHash(ro.name)
+		if err != nil {
+			return err
+		}
+		if hash == ro.configHash {
+			kept = append(kept, ro)
+			continue
+		}
+
+		log.Printf("Reconnecting changed output: %s\n", ro.name)
+		if err := a.reconnectOutput(config, ro, hash); err != nil {
+			return err
+		}
+		kept = append(kept, ro)
+	}
+
+	for name := range wanted {
+		if outputIsRunning(kept, name) {
+			continue
+		}
+
+		added, err := a.startOutput(config, name)
+		if err != nil {
+			return err
+		}
+		kept = append(kept, added)
+	}
+
+	a.outputs = kept
+	return nil
+}
+
+// reconnectOutput replaces ro's output connection and filter in place with
+// ones built from the new config, leaving ro's buffer (and anything queued
+// in it) untouched.
+func (a *Agent) reconnectOutput(config *Config, ro *runningOutput, hash string) error {
+	creator, ok := outputs.Outputs[ro.name]
+	if !ok {
+		return fmt.Errorf("Undefined but requested output: %s", ro.name)
+	}
+	output := creator()
+
+	if err := config.ApplyOutput(ro.name, output); err != nil {
+		return err
+	}
+	if err := output.Connect(); err != nil {
+		return fmt.Errorf("reconnecting output %s: %s", ro.name, err)
+	}
+
+	filter, err := config.OutputFilter(ro.name)
+	if err != nil {
+		return err
+	}
+	if err := filter.Compile(); err != nil {
+		return fmt.Errorf("output %s: %s", ro.name, err)
+	}
+
+	ro.mu.Lock()
+	oldOutput := ro.output
+	ro.output = output
+	ro.filter = filter
+	ro.configHash = hash
+	ro.backoff = 0
+	ro.mu.Unlock()
+
+	if err := oldOutput.Close(); err != nil {
+		log.Printf("Error closing previous connection for output %s: %s\n", ro.name, err.Error())
+	}
+
+	return nil
+}
+
+// startOutput creates, configures, connects and buffers a fresh instance
+// of the named output, tagging it with its current config hash so a
+// future reload can tell whether its config has changed again.
+func (a *Agent) startOutput(config *Config, name string) (*runningOutput, error) {
+	creator, ok := outputs.Outputs[name]
+	if !ok {
+		return nil, fmt.Errorf("Undefined but requested output: %s", name)
+	}
+	output := creator()
+
+	if err := config.ApplyOutput(name, output); err != nil {
+		return nil, err
+	}
+	if err := output.Connect(); err != nil {
+		return nil, fmt.Errorf("connecting added output %s: %s", name, err)
+	}
+
+	buffer, err := newBuffer(name, a.MaxBufferSize, BufferDropPolicy(a.BufferDropPolicy), a.bufferDir())
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := config.OutputFilter(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := filter.Compile(); err != nil {
+		return nil, fmt.Errorf("output %s: %s", name, err)
+	}
+
+	hash, err := config.OutputConfigHash(name)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Starting added output: %s\n", name)
+	return &runningOutput{name: name, output: output, buffer: buffer, filter: filter, configHash: hash}, nil
+}
+
+func outputIsRunning(running []*runningOutput, name string) bool {
+	for _, ro := range running {
+		if ro.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchForReload blocks waiting for SIGHUP, calling Reload with the given
+// config and filters each time one arrives, until shutdown is closed. Run
+// starts this automatically using the config the Agent was built from.
+func (a *Agent) WatchForReload(shutdown chan struct{}, config *Config, pluginFilters, outputFilters []string) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	for {
+		select {
+		case <-shutdown:
+			return
+		case <-sighup:
+			log.Println("Received SIGHUP, reloading config")
+			if err := a.Reload(config, pluginFilters, outputFilters); err != nil {
+				log.Printf("Error reloading config: %s\n", err.Error())
+			}
+		}
+	}
+}